@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"k8s.io/test-infra/prow/github"
+
+	"github.com/openshift/ci-tools/pkg/config"
+)
+
+// mismatch records a source file that does not belong to any auto-updating
+// ConfigMap, or that matched a glob for the wrong one.
+type mismatch struct {
+	path              string
+	expectedConfigMap string
+}
+
+// fixConfigUpdaterMaps adds a `<dir>/*<ext>` glob entry to the
+// `config_updater.maps` section of plugins.yaml for each file in mismatches,
+// pointing at the file's expected ConfigMap. A file whose `<dir>/*<ext>` glob
+// is already present is skipped, whatever ConfigMap that entry names, and
+// other entries that also match a file are left in place. With o.dryRun the
+// result is printed; otherwise, when o.githubToken is set, it is written,
+// committed on a branch in o.releaseRepoDir and proposed as a PR against
+// o.org/o.repo, and else it is only written to plugins.yaml in place.
+func fixConfigUpdaterMaps(o options, mismatches []mismatch) error {
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	pluginConfigPath := path.Join(o.releaseRepoDir, config.PluginConfigInRepoPath)
+	raw, err := ioutil.ReadFile(pluginConfigPath)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", pluginConfigPath, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return fmt.Errorf("could not parse %s: %w", pluginConfigPath, err)
+	}
+
+	mapsNode, err := findOrCreateMapsNode(&root)
+	if err != nil {
+		return err
+	}
+
+	// Sort for a deterministic, reviewable diff regardless of map iteration order.
+	sorted := append([]mismatch(nil), mismatches...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+
+	var added int
+	for _, m := range sorted {
+		glob := path.Join(path.Dir(m.path), "*"+path.Ext(m.path))
+		if globExists(mapsNode, glob) {
+			continue
+		}
+		appendMapEntry(mapsNode, glob, m.expectedConfigMap)
+		added++
+		logrus.WithFields(logrus.Fields{"glob": glob, "config-map": m.expectedConfigMap}).Info("Adding config_updater.maps entry.")
+	}
+	if added == 0 {
+		logrus.Info("No new config_updater.maps entries were needed.")
+		return nil
+	}
+
+	out, err := yaml.Marshal(&root)
+	if err != nil {
+		return fmt.Errorf("could not re-marshal %s: %w", pluginConfigPath, err)
+	}
+
+	if o.dryRun {
+		fmt.Printf("--- %s (proposed)\n%s\n", pluginConfigPath, out)
+		return nil
+	}
+
+	if o.githubToken != "" {
+		return openConfigUpdaterPR(o, pluginConfigPath, out)
+	}
+
+	return ioutil.WriteFile(pluginConfigPath, out, 0644)
+}
+
+// findOrCreateMapsNode walks the document down to config_updater.maps,
+// operating on yaml.Node directly so that comments and key ordering
+// elsewhere in the document are preserved on re-marshal. It does not create
+// missing sections: a plugins.yaml without config_updater or
+// config_updater.maps is an error.
+func findOrCreateMapsNode(root *yaml.Node) (*yaml.Node, error) {
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("plugins.yaml is empty")
+	}
+	doc := root.Content[0]
+	configUpdater := mappingValue(doc, "config_updater")
+	if configUpdater == nil {
+		return nil, fmt.Errorf("plugins.yaml has no config_updater section")
+	}
+	maps := mappingValue(configUpdater, "maps")
+	if maps == nil {
+		return nil, fmt.Errorf("plugins.yaml has no config_updater.maps section")
+	}
+	return maps, nil
+}
+
+// mappingValue returns the value node for key in a !!map node, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func globExists(maps *yaml.Node, glob string) bool {
+	for i := 0; i+1 < len(maps.Content); i += 2 {
+		if maps.Content[i].Value == glob {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMapEntry adds `glob: {name: configMapName}` to the end of the
+// config_updater.maps mapping node.
+func appendMapEntry(maps *yaml.Node, glob, configMapName string) {
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: glob}
+	nameKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"}
+	nameValue := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: configMapName}
+	valueNode := &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Content: []*yaml.Node{nameKey, nameValue},
+	}
+	maps.Content = append(maps.Content, keyNode, valueNode)
+}
+
+// openConfigUpdaterPR commits the rewritten plugins.yaml on a dedicated
+// branch, pushes that branch to the openshift-bot fork, and opens a PR
+// against o.org/o.repo proposing it.
+func openConfigUpdaterPR(o options, pluginConfigPath string, content []byte) error {
+	const (
+		remoteBranch  = "auto-fix-config-updater-maps"
+		commitMessage = "config-shard-validator: repair config_updater.maps"
+		title         = commitMessage
+	)
+
+	if err := ioutil.WriteFile(pluginConfigPath, content, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", pluginConfigPath, err)
+	}
+
+	if err := commitAndPushBranch(o, remoteBranch, commitMessage); err != nil {
+		return fmt.Errorf("could not commit and push %s: %w", remoteBranch, err)
+	}
+
+	gc := github.NewClient(func() []byte { return []byte(o.githubToken) }, func() []byte { return nil }, github.DefaultGraphQLEndpoint, github.DefaultAPIEndpoint)
+
+	body := fmt.Sprintf("This PR was generated automatically by config-shard-validator to repair `config_updater.maps` entries for source files that did not match any auto-updating ConfigMap, or matched the wrong one.\n\nBranch: `%s`", remoteBranch)
+	if _, err := gc.CreatePullRequest(o.org, o.repo, title, body, "openshift-bot:"+remoteBranch, "master", true); err != nil {
+		return fmt.Errorf("could not create pull request: %w", err)
+	}
+	return nil
+}
+
+// commitAndPushBranch stages the rewritten plugins.yaml in o.releaseRepoDir,
+// commits it to a fresh branch, and force-pushes that branch to the
+// openshift-bot fork, so the PR opened afterward has a head ref to point at
+// that actually carries the diff. The token is handed to git through a
+// GIT_ASKPASS helper rather than embedded in the remote URL, so it never
+// appears in the command args that get joined into an error message (and,
+// on failure, logged) if a git command fails.
+func commitAndPushBranch(o options, branch, message string) error {
+	remote := fmt.Sprintf("https://github.com/openshift-bot/%s.git", o.repo)
+
+	askpass, cleanup, err := githubTokenAskpass()
+	if err != nil {
+		return fmt.Errorf("could not set up push credentials: %w", err)
+	}
+	defer cleanup()
+
+	commands := [][]string{
+		{"checkout", "-B", branch},
+		{"add", "--", config.PluginConfigInRepoPath},
+		{"commit", "-m", message},
+		{"push", "--force", remote, branch},
+	}
+	for _, args := range commands {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = o.releaseRepoDir
+		cmd.Env = append(os.Environ(),
+			"GIT_ASKPASS="+askpass,
+			"GIT_TERMINAL_PROMPT=0",
+			"CONFIG_SHARD_VALIDATOR_GITHUB_TOKEN="+o.githubToken,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return nil
+}
+
+// githubTokenAskpass writes a throwaway GIT_ASKPASS helper script that prints
+// the CONFIG_SHARD_VALIDATOR_GITHUB_TOKEN environment variable back to git on
+// a password prompt, so the token is supplied through the environment rather
+// than argv and can't end up in a command's args (and therefore can't leak
+// through an error message built from those args). The caller must invoke
+// the returned cleanup func once the git commands that need it have finished.
+func githubTokenAskpass() (scriptPath string, cleanup func(), err error) {
+	f, err := ioutil.TempFile("", "config-shard-validator-askpass-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("could not create askpass helper: %w", err)
+	}
+	cleanup = func() { _ = os.Remove(f.Name()) }
+
+	const script = "#!/bin/sh\ncase \"$1\" in\nUsername*) echo openshift-bot ;;\n*) echo \"$CONFIG_SHARD_VALIDATOR_GITHUB_TOKEN\" ;;\nesac\n"
+	if _, err := f.WriteString(script); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("could not write askpass helper: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("could not write askpass helper: %w", err)
+	}
+	if err := os.Chmod(f.Name(), 0700); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("could not make askpass helper executable: %w", err)
+	}
+	return f.Name(), cleanup, nil
+}
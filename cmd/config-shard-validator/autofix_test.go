@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/openshift/ci-tools/pkg/config"
+)
+
+const testPluginsYAML = `# a leading comment that a full rewrite would otherwise drop
+config_updater:
+  maps:
+    ci-operator/config/existing/repo/*.yaml: # a trailing comment
+      name: existing-configmap
+`
+
+func writeTestPluginsYAML(t *testing.T, dir string) {
+	t.Helper()
+	path := filepath.Join(dir, config.PluginConfigInRepoPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(testPluginsYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixConfigUpdaterMapsAddsMissingEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPluginsYAML(t, dir)
+
+	o := options{releaseRepoDir: dir}
+	mismatches := []mismatch{
+		{path: "ci-operator/config/new/repo/new-repo-main.yaml", expectedConfigMap: "new-repo-main"},
+	}
+
+	if err := fixConfigUpdaterMaps(o, mismatches); err != nil {
+		t.Fatalf("fixConfigUpdaterMaps() returned error: %v", err)
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, config.PluginConfigInRepoPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		t.Fatalf("rewritten plugins.yaml does not parse: %v", err)
+	}
+	maps, err := findOrCreateMapsNode(&root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !globExists(maps, "ci-operator/config/existing/repo/*.yaml") {
+		t.Error("expected the pre-existing glob entry to survive the rewrite")
+	}
+	if !globExists(maps, "ci-operator/config/new/repo/*.yaml") {
+		t.Error("expected a new glob entry for the mismatched file's directory")
+	}
+	if !strings.Contains(string(raw), "a leading comment") {
+		t.Error("expected the leading comment in the original plugins.yaml to survive the yaml.Node round-trip")
+	}
+}
+
+func TestFixConfigUpdaterMapsIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPluginsYAML(t, dir)
+
+	o := options{releaseRepoDir: dir}
+	mismatches := []mismatch{
+		{path: "ci-operator/config/existing/repo/existing-repo-main.yaml", expectedConfigMap: "existing-configmap"},
+	}
+
+	if err := fixConfigUpdaterMaps(o, mismatches); err != nil {
+		t.Fatalf("fixConfigUpdaterMaps() returned error: %v", err)
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, config.PluginConfigInRepoPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		t.Fatal(err)
+	}
+	maps, err := findOrCreateMapsNode(&root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(maps.Content) != 2 {
+		t.Fatalf("expected no new entry for a file whose glob already exists, got %d map entries (%d key/value pairs)", len(maps.Content)/2, len(maps.Content))
+	}
+}
+
+func TestCommitAndPushBranchDoesNotLeakTokenOnFailure(t *testing.T) {
+	const token = "super-secret-token-value"
+	o := options{releaseRepoDir: t.TempDir(), repo: "release", githubToken: token}
+
+	// releaseRepoDir is not a git repository, so "git checkout -B" fails
+	// immediately and commitAndPushBranch never gets as far as "git push" --
+	// this only needs to prove that whatever args/output do make it into the
+	// returned error never contain the token.
+	err := commitAndPushBranch(o, "auto-fix-config-updater-maps", "repair config_updater.maps")
+	if err == nil {
+		t.Fatal("expected commitAndPushBranch to fail against a non-git directory")
+	}
+	if strings.Contains(err.Error(), token) {
+		t.Errorf("commitAndPushBranch() error leaks the GitHub token: %v", err)
+	}
+}
+
+func TestGithubTokenAskpassPrintsTokenFromEnvironment(t *testing.T) {
+	scriptPath, cleanup, err := githubTokenAskpass()
+	if err != nil {
+		t.Fatalf("githubTokenAskpass() returned error: %v", err)
+	}
+	defer cleanup()
+
+	cmd := exec.Command(scriptPath, "Password for 'https://github.com':")
+	cmd.Env = append(os.Environ(), "CONFIG_SHARD_VALIDATOR_GITHUB_TOKEN=expected-token")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("askpass helper returned error: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "expected-token" {
+		t.Errorf("askpass helper printed %q, want %q", got, "expected-token")
+	}
+}
+
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/ci-tools/pkg/api"
+	"github.com/openshift/ci-tools/pkg/config"
+	"github.com/openshift/ci-tools/pkg/jobconfig"
+)
+
+// gatherConfigs walks the CI Operator configs and Prow job configs in the
+// release repo, returning every source file that needs to belong to an
+// auto-updating ConfigMap and the CI Operator configs indexed by basename
+// (for checkSpec). Any CONFIG_SPEC violations found while walking the job
+// configs are reported through rec.
+func gatherConfigs(o options, clustersByConfigMap map[string]map[string]bool, rec *recorder) ([]pathWithConfig, map[string]*config.Info) {
+	var pathsToCheck []pathWithConfig
+	configInfos := map[string]*config.Info{}
+	if err := config.OperateOnCIOperatorConfigDir(path.Join(o.releaseRepoDir, config.CiopConfigInRepoPath), func(configuration *api.ReleaseBuildConfiguration, info *config.Info) error {
+		// we know the path is relative, but there is no API to declare that
+		relPath, _ := filepath.Rel(o.releaseRepoDir, info.Filename)
+		pathsToCheck = append(pathsToCheck, pathWithConfig{path: relPath, configMap: info.ConfigMapName()})
+		configInfos[info.Basename()] = info
+		return nil
+	}); err != nil {
+		logrus.WithError(err).Fatal("Could not load CI Operator configurations.")
+	}
+
+	if err := jobconfig.OperateOnJobConfigDir(path.Join(o.releaseRepoDir, config.JobConfigInRepoPath), func(jobConfig *prowconfig.JobConfig, info *jobconfig.Info) error {
+		// we know the path is relative, but there is no API to declare that
+		relPath, _ := filepath.Rel(o.releaseRepoDir, info.Filename)
+		pathsToCheck = append(pathsToCheck, pathWithConfig{path: relPath, configMap: info.ConfigMapName()})
+		for _, presubmits := range jobConfig.PresubmitsStatic {
+			for _, presubmit := range presubmits {
+				if presubmit.Spec != nil {
+					checkSpec(presubmit.Spec, relPath, presubmit.Name, presubmit.Cluster, o.defaultCluster, configInfos, clustersByConfigMap, rec)
+				}
+			}
+		}
+		for _, postsubmits := range jobConfig.PostsubmitsStatic {
+			for _, postsubmit := range postsubmits {
+				if postsubmit.Spec != nil {
+					checkSpec(postsubmit.Spec, relPath, postsubmit.Name, postsubmit.Cluster, o.defaultCluster, configInfos, clustersByConfigMap, rec)
+				}
+			}
+		}
+		for _, periodic := range jobConfig.Periodics {
+			if periodic.Spec != nil {
+				checkSpec(periodic.Spec, relPath, periodic.Name, periodic.Cluster, o.defaultCluster, configInfos, clustersByConfigMap, rec)
+			}
+		}
+		return nil
+	}); err != nil {
+		logrus.WithError(err).Fatal("Could not load Prow job configurations.")
+	}
+
+	return pathsToCheck, configInfos
+}
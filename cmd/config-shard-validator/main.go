@@ -1,31 +1,49 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
 
 	"k8s.io/api/core/v1"
 	prowv1 "k8s.io/test-infra/prow/apis/prowjobs/v1"
-	prowconfig "k8s.io/test-infra/prow/config"
 	_ "k8s.io/test-infra/prow/hook"
 	"k8s.io/test-infra/prow/plugins"
 
 	"github.com/mattn/go-zglob"
 	"github.com/sirupsen/logrus"
 
-	"github.com/openshift/ci-tools/pkg/api"
 	"github.com/openshift/ci-tools/pkg/config"
-	"github.com/openshift/ci-tools/pkg/jobconfig"
+	ciio "github.com/openshift/ci-tools/pkg/io"
 )
 
 type options struct {
 	releaseRepoDir string
 
 	logLevel string
+
+	autoFix bool
+	dryRun  bool
+
+	githubToken string
+	org         string
+	repo        string
+
+	shard             bool
+	maxShardBytesGzip int64
+
+	report             bool
+	reportFormat       string
+	reportOutput       string
+	highWaterThreshold float64
+
+	statePath          string
+	gcsCredentialsFile string
+
+	defaultCluster string
 }
 
 func (o *options) Validate() error {
@@ -38,12 +56,52 @@ func (o *options) Validate() error {
 		return fmt.Errorf("invalid --log-level: %v", err)
 	}
 	logrus.SetLevel(level)
+
+	if o.githubToken != "" && (o.org == "" || o.repo == "") {
+		return errors.New("--github-token requires both --org and --repo to be set")
+	}
+	if (o.org != "" || o.repo != "") && !o.autoFix {
+		return errors.New("--org and --repo require --auto-fix to be set")
+	}
+
+	if o.reportFormat != "json" && o.reportFormat != "markdown" {
+		return fmt.Errorf("invalid --report-format: %s, must be one of: json, markdown", o.reportFormat)
+	}
+	if o.highWaterThreshold <= 0 || o.highWaterThreshold > 1 {
+		return fmt.Errorf("invalid --high-water-threshold: %v, must be in (0, 1]", o.highWaterThreshold)
+	}
+	if o.defaultCluster == "" {
+		return errors.New("required flag --default-cluster was unset")
+	}
+	if o.defaultCluster == prowv1.DefaultClusterAlias {
+		return fmt.Errorf("--default-cluster must be the real name of the cluster jobs run on when unset, not the %q alias: config_updater.maps entries are not allowed to use that alias, so validating against it would always fail", prowv1.DefaultClusterAlias)
+	}
 	return nil
 }
 
 func (o *options) Bind(fs *flag.FlagSet) {
 	fs.StringVar(&o.releaseRepoDir, "release-repo-dir", "", "Path to openshift/release repo.")
 	fs.StringVar(&o.logLevel, "log-level", "info", "Level at which to log output.")
+
+	fs.BoolVar(&o.autoFix, "auto-fix", false, "Rewrite plugins.yaml to add/repair config_updater.maps entries for mismatched source files instead of only failing.")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "When combined with --auto-fix or --shard, print the proposed rewritten plugins.yaml instead of writing it or opening a PR.")
+
+	fs.StringVar(&o.githubToken, "github-token", "", "Token for the GitHub account that --auto-fix should use to commit the rewritten plugins.yaml on a branch, push it and open a PR with it, instead of only writing it locally.")
+	fs.StringVar(&o.org, "org", "", "Org that hosts the release repo, used when opening a PR for --auto-fix.")
+	fs.StringVar(&o.repo, "repo", "", "Repo that hosts the release repo, used when opening a PR for --auto-fix.")
+
+	fs.BoolVar(&o.shard, "shard", false, "Compute a stable ConfigMap shard assignment for the discovered configs instead of linting config_updater.maps.")
+	fs.Int64Var(&o.maxShardBytesGzip, "max-shard-bytes-gzip", 950000, "Gzip-compressed byte budget a single ConfigMap shard must fit under. Kubernetes caps ConfigMaps at 1MiB; this should stay below that with headroom.")
+
+	fs.BoolVar(&o.report, "report", false, "Report raw and gzip-compressed payload size per config_updater.maps entry instead of linting config_updater.maps.")
+	fs.StringVar(&o.reportFormat, "report-format", "json", "Format for --report output: json or markdown.")
+	fs.StringVar(&o.reportOutput, "report-output", "", "File to write the --report output to. Defaults to stdout.")
+	fs.Float64Var(&o.highWaterThreshold, "high-water-threshold", 0.8, "Fraction of the Kubernetes ConfigMap size limit at which --report flags a shard as near-capacity.")
+
+	fs.StringVar(&o.statePath, "state-path", "", "Local file or gs:// URI holding the set of previously-known violations. When set, only newly-introduced violations fail the build; pre-existing ones are logged as warnings and the file is rewritten with the current set.")
+	fs.StringVar(&o.gcsCredentialsFile, "gcs-credentials-file", "", "Path to a GCS service account credentials file used when --state-path is a gs:// URI. Empty uses the ambient application-default credentials.")
+
+	fs.StringVar(&o.defaultCluster, "default-cluster", "", "Real name of the build cluster a job runs on when its cluster: field is left unset. config_updater.maps entries must list real cluster names (the \"default\" alias is rejected), so this is required to validate those jobs against clustersByConfigMap.")
 }
 
 func gatherOptions() options {
@@ -72,61 +130,54 @@ func main() {
 	}
 	pcfg := pluginAgent.Config()
 
-	var pathsToCheck []pathWithConfig
-	configInfos := map[string]*config.Info{}
-	if err := config.OperateOnCIOperatorConfigDir(path.Join(o.releaseRepoDir, config.CiopConfigInRepoPath), func(configuration *api.ReleaseBuildConfiguration, info *config.Info) error {
-		// we know the path is relative, but there is no API to declare that
-		relPath, _ := filepath.Rel(o.releaseRepoDir, info.Filename)
-		pathsToCheck = append(pathsToCheck, pathWithConfig{path: relPath, configMap: info.ConfigMapName()})
-		configInfos[info.Basename()] = info
-		return nil
-	}); err != nil {
-		logrus.WithError(err).Fatal("Could not load CI Operator configurations.")
-	}
-
-	var foundFailures bool
-	if err := jobconfig.OperateOnJobConfigDir(path.Join(o.releaseRepoDir, config.JobConfigInRepoPath), func(jobConfig *prowconfig.JobConfig, info *jobconfig.Info) error {
-		// we know the path is relative, but there is no API to declare that
-		relPath, _ := filepath.Rel(o.releaseRepoDir, info.Filename)
-		pathsToCheck = append(pathsToCheck, pathWithConfig{path: relPath, configMap: info.ConfigMapName()})
-		for _, presubmits := range jobConfig.PresubmitsStatic {
-			for _, presubmit := range presubmits {
-				if presubmit.Spec != nil {
-					if foundFailure := checkSpec(presubmit.Spec, relPath, presubmit.Name, configInfos); foundFailure {
-						foundFailures = true
-					}
-				}
-			}
+	clustersByConfigMap := map[string]map[string]bool{}
+	for _, updateConfig := range pcfg.ConfigUpdater.Maps {
+		if clustersByConfigMap[updateConfig.Name] == nil {
+			clustersByConfigMap[updateConfig.Name] = map[string]bool{}
 		}
-		for _, postsubmits := range jobConfig.PostsubmitsStatic {
-			for _, postsubmit := range postsubmits {
-				if postsubmit.Spec != nil {
-					if foundFailure := checkSpec(postsubmit.Spec, relPath, postsubmit.Name, configInfos); foundFailure {
-						foundFailures = true
-					}
-				}
-			}
+		for cluster := range updateConfig.Clusters {
+			clustersByConfigMap[updateConfig.Name][cluster] = true
 		}
-		for _, periodic := range jobConfig.Periodics {
-			if periodic.Spec != nil {
-				if foundFailure := checkSpec(periodic.Spec, relPath, periodic.Name, configInfos); foundFailure {
-					foundFailures = true
-				}
-			}
+	}
+
+	ctx := context.Background()
+	opener, err := ciio.NewOpener(ctx, o.gcsCredentialsFile)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not initialize local/GCS file opener.")
+	}
+	prior, bootstrap, err := loadState(ctx, o.statePath, opener)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not load --state-path.")
+	}
+	rec := newRecorder(o.statePath != "", bootstrap, prior)
+
+	pathsToCheck, configInfos := gatherConfigs(o, clustersByConfigMap, rec)
+
+	if o.shard {
+		if err := runShardMode(o, pathsToCheck); err != nil {
+			logrus.WithError(err).Fatal("Could not compute ConfigMap shard assignment.")
+		}
+		return
+	}
+
+	if o.report {
+		if err := runReportMode(o, pcfg, pathsToCheck); err != nil {
+			logrus.WithError(err).Fatal("ConfigMap size report found budget violations.")
 		}
-		return nil
-	}); err != nil {
-		logrus.WithError(err).Fatal("Could not load Prow job configurations.")
+		return
 	}
 
+	var mismatches []mismatch
 	for _, pathToCheck := range pathsToCheck {
 		var matchesAny bool
 		var matchedMap string
 		logger := logrus.WithField("source-file", pathToCheck.path)
 		for glob, updateConfig := range pcfg.ConfigUpdater.Maps {
 			if _, hasDefaultCluster := updateConfig.Clusters[prowv1.DefaultClusterAlias]; hasDefaultCluster {
-				logger.Errorf("cluster alias %s is not allowed, please explicitly specific the name of the cluster", prowv1.DefaultClusterAlias)
-				foundFailures = true
+				rec.violation(logger, "default-cluster-alias|"+glob, fmt.Sprintf("cluster alias %s is not allowed, please explicitly specific the name of the cluster", prowv1.DefaultClusterAlias))
+			}
+			if len(updateConfig.Clusters) == 0 {
+				rec.violation(logger.WithField("config-map", updateConfig.Name), "empty-clusters|"+updateConfig.Name, "ConfigMap is not published to any cluster.")
 			}
 
 			globLogger := logger.WithField("glob", glob)
@@ -135,38 +186,70 @@ func main() {
 				globLogger.WithError(matchErr).Warn("Failed to check glob match.")
 			}
 			if jobConfigMatch, err := zglob.Match(glob, "ci-operator/jobs"); err != nil {
-				globLogger.WithError(err).Error("failed to check if config is a jobconfig")
-				foundFailures = true
+				rec.violation(globLogger.WithError(err), "jobconfig-glob-error|"+glob, "failed to check if config is a jobconfig")
 			} else if jobConfigMatch && (updateConfig.GZIP == nil || !*updateConfig.GZIP) {
-				globLogger.Error("gzip must be enabled for job configs")
-				foundFailures = true
+				rec.violation(globLogger, "gzip-required|"+glob, "gzip must be enabled for job configs")
 			}
 			if matches {
 				if matchesAny {
-					globLogger.Errorf("File matches glob from more than one ConfigMap: %s, %s.", matchedMap, pathToCheck.configMap)
-					foundFailures = true
+					rec.violation(globLogger, "duplicate-glob-match|"+pathToCheck.path, fmt.Sprintf("File matches glob from more than one ConfigMap: %s, %s.", matchedMap, pathToCheck.configMap))
 				}
 				if updateConfig.Name != pathToCheck.configMap {
-					globLogger.Errorf("File matches glob from unexpected ConfigMap %s instead of %s.", updateConfig.Name, pathToCheck.configMap)
-					foundFailures = true
+					rec.violation(globLogger, "wrong-config-map|"+pathToCheck.path, fmt.Sprintf("File matches glob from unexpected ConfigMap %s instead of %s.", updateConfig.Name, pathToCheck.configMap))
+					mismatches = append(mismatches, mismatch{path: pathToCheck.path, expectedConfigMap: pathToCheck.configMap})
 				}
 				matchesAny = true
 				matchedMap = pathToCheck.configMap
 			}
 		}
 		if !matchesAny {
-			logger.Error("Config file does not belong to any auto-updating config.")
-			foundFailures = true
+			rec.violation(logger, "unmatched-path|"+pathToCheck.path, "Config file does not belong to any auto-updating config.")
+			mismatches = append(mismatches, mismatch{path: pathToCheck.path, expectedConfigMap: pathToCheck.configMap})
+		}
+	}
+
+	if o.statePath != "" {
+		if err := saveState(ctx, o.statePath, rec.state(), opener); err != nil {
+			logrus.WithError(err).Fatal("Could not write --state-path.")
+		}
+	}
+
+	if rec.failed() && o.autoFix {
+		if err := fixConfigUpdaterMaps(o, mismatches); err != nil {
+			logrus.WithError(err).Fatal("Could not auto-fix config_updater.maps entries.")
 		}
+		return
 	}
 
-	if foundFailures {
+	if rec.failed() {
 		logrus.Fatal("Found configurations that do not belong to the correct auto-updating config")
 	}
 }
 
-func checkSpec(spec *v1.PodSpec, relPath, name string, configInfos map[string]*config.Info) bool {
-	var foundFailures bool
+// resolveJobCluster returns the cluster a job actually runs on: jobCluster
+// verbatim if the job set one, otherwise defaultCluster. Pulled out as its
+// own function so the fallback can be unit-tested without needing a real
+// config.Info to drive checkSpec end-to-end.
+func resolveJobCluster(jobCluster, defaultCluster string) string {
+	if jobCluster == "" {
+		return defaultCluster
+	}
+	return jobCluster
+}
+
+// checkSpec validates the CONFIG_SPEC references in a job's pod spec,
+// including that the job's build cluster is one the referenced ConfigMap is
+// actually published to: clustersByConfigMap maps a ConfigMap name to the set
+// of clusters listed for it across config_updater.maps. Violations are
+// reported through rec rather than returned, so they participate in the
+// same --state-path ratcheting as the rest of the tool's checks. defaultCluster
+// is the real cluster a job with an unset cluster: field runs on; it must not
+// be prowv1.DefaultClusterAlias, since config_updater.maps entries are never
+// allowed to use that alias as a cluster key (see the default-cluster-alias
+// check above), which would otherwise make every unset-cluster job a false
+// positive here.
+func checkSpec(spec *v1.PodSpec, relPath, name, jobCluster, defaultCluster string, configInfos map[string]*config.Info, clustersByConfigMap map[string]map[string]bool, rec *recorder) {
+	jobCluster = resolveJobCluster(jobCluster, defaultCluster)
 	for containerIndex, container := range spec.Containers {
 		for _, env := range container.Env {
 			if env.Name == "CONFIG_SPEC" && env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
@@ -176,21 +259,26 @@ func checkSpec(spec *v1.PodSpec, relPath, name string, configInfos map[string]*c
 					"container":   containerIndex,
 					"key":         env.ValueFrom.ConfigMapKeyRef.Key,
 				})
+				key := fmt.Sprintf("%s|%s|%s", relPath, name, env.ValueFrom.ConfigMapKeyRef.Key)
 				configInfo, exists := configInfos[env.ValueFrom.ConfigMapKeyRef.Key]
 				if !exists {
-					logger.Error("Could not find CI Operator configuration file for that key.")
-					foundFailures = true
+					rec.violation(logger, "configspec-missing-info|"+key, "Could not find CI Operator configuration file for that key.")
 					continue
 				}
 				if env.ValueFrom.ConfigMapKeyRef.Name != configInfo.ConfigMapName() {
-					logger.WithFields(logrus.Fields{
+					rec.violation(logger.WithFields(logrus.Fields{
 						"got":      env.ValueFrom.ConfigMapKeyRef.Name,
 						"expected": configInfo.ConfigMapName(),
-					}).Error("Invalid config map shard for injected CI-Operator config key.")
-					foundFailures = true
+					}), "configspec-wrong-shard|"+key, "Invalid config map shard for injected CI-Operator config key.")
+					continue
+				}
+				if !clustersByConfigMap[configInfo.ConfigMapName()][jobCluster] {
+					rec.violation(logger.WithFields(logrus.Fields{
+						"cluster":    jobCluster,
+						"config-map": configInfo.ConfigMapName(),
+					}), "configspec-wrong-cluster|"+key, "Job runs on a cluster that the referenced ConfigMap is not published to.")
 				}
 			}
 		}
 	}
-	return foundFailures
 }
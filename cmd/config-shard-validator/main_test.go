@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestResolveJobCluster(t *testing.T) {
+	cases := []struct {
+		name           string
+		jobCluster     string
+		defaultCluster string
+		want           string
+	}{
+		{
+			name:           "unset cluster falls back to the real default, not the banned alias",
+			jobCluster:     "",
+			defaultCluster: "build01",
+			want:           "build01",
+		},
+		{
+			name:           "explicit cluster is used as-is",
+			jobCluster:     "build02",
+			defaultCluster: "build01",
+			want:           "build02",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resolveJobCluster(tc.jobCluster, tc.defaultCluster); got != tc.want {
+				t.Errorf("resolveJobCluster(%q, %q) = %q, want %q", tc.jobCluster, tc.defaultCluster, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/mattn/go-zglob"
+	"k8s.io/test-infra/prow/plugins"
+)
+
+// k8sConfigMapByteLimit is the size, in bytes, above which the Kubernetes API
+// server rejects a ConfigMap.
+const k8sConfigMapByteLimit = 1024 * 1024
+
+// configMapUsage is one row of the --report output: the raw and
+// gzip-compressed size of the payload a config_updater.maps glob would
+// produce, measured against the Kubernetes ConfigMap size limit. The measured
+// size is the gzip-compressed one for entries with gzip enabled and the raw
+// one otherwise.
+type configMapUsage struct {
+	Glob          string  `json:"glob"`
+	ConfigMapName string  `json:"configMapName"`
+	RawBytes      int64   `json:"rawBytes"`
+	GzipBytes     int64   `json:"gzipBytes"`
+	Limit         int64   `json:"limit"`
+	UsageFraction float64 `json:"usageFraction"`
+	HighWater     bool    `json:"highWater"`
+	OverLimit     bool    `json:"overLimit"`
+}
+
+// runReportMode computes, for each glob in pcfg.ConfigUpdater.Maps, the raw
+// and gzip-compressed size of the ConfigMap payload it would produce from
+// pathsToCheck, and writes a size-usage report in o.reportFormat. It returns
+// an error if any ConfigMap's projected size exceeds the Kubernetes limit.
+func runReportMode(o options, pcfg *plugins.Configuration, pathsToCheck []pathWithConfig) error {
+	var usages []configMapUsage
+	var overLimit []string
+	for glob, updateConfig := range pcfg.ConfigUpdater.Maps {
+		var matched []string
+		for _, p := range pathsToCheck {
+			if matches, err := zglob.Match(glob, p.path); err == nil && matches {
+				matched = append(matched, p.path)
+			}
+		}
+
+		raw, gzipped, err := payloadSizes(o.releaseRepoDir, matched)
+		if err != nil {
+			return fmt.Errorf("glob %s: %w", glob, err)
+		}
+
+		usage := configMapUsage{
+			Glob:          glob,
+			ConfigMapName: updateConfig.Name,
+			RawBytes:      raw,
+			GzipBytes:     gzipped,
+			Limit:         k8sConfigMapByteLimit,
+		}
+		payload := raw
+		if updateConfig.GZIP != nil && *updateConfig.GZIP {
+			payload = gzipped
+		}
+		usage.UsageFraction, usage.HighWater, usage.OverLimit = classifyUsage(payload, o.highWaterThreshold)
+		if usage.OverLimit {
+			overLimit = append(overLimit, usage.ConfigMapName)
+		}
+		usages = append(usages, usage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool { return usages[i].ConfigMapName < usages[j].ConfigMapName })
+
+	var rendered []byte
+	var err error
+	switch o.reportFormat {
+	case "json":
+		rendered, err = json.MarshalIndent(usages, "", "  ")
+	case "markdown":
+		rendered = []byte(renderMarkdownReport(usages))
+	default:
+		err = fmt.Errorf("unknown report format %q", o.reportFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("could not render report: %w", err)
+	}
+
+	if o.reportOutput == "" {
+		fmt.Println(string(rendered))
+	} else if err := ioutil.WriteFile(o.reportOutput, rendered, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", o.reportOutput, err)
+	}
+
+	if len(overLimit) > 0 {
+		return fmt.Errorf("%d ConfigMap(s) exceed the %d byte limit: %v", len(overLimit), k8sConfigMapByteLimit, overLimit)
+	}
+	return nil
+}
+
+// classifyUsage computes the fraction of the Kubernetes ConfigMap byte limit
+// that payloadBytes consumes, and whether that crosses the --high-water-threshold
+// or the hard limit. Pulled out of runReportMode's loop so the threshold
+// logic can be unit-tested without constructing a plugins.Configuration.
+func classifyUsage(payloadBytes int64, highWaterThreshold float64) (usageFraction float64, highWater, overLimit bool) {
+	usageFraction = float64(payloadBytes) / float64(k8sConfigMapByteLimit)
+	highWater = usageFraction >= highWaterThreshold
+	overLimit = payloadBytes > k8sConfigMapByteLimit
+	return usageFraction, highWater, overLimit
+}
+
+func renderMarkdownReport(usages []configMapUsage) string {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "| ConfigMap | Glob | Raw bytes | Gzip bytes | Usage | Status |")
+	fmt.Fprintln(&buf, "|---|---|---|---|---|---|")
+	for _, u := range usages {
+		status := "ok"
+		if u.OverLimit {
+			status = "OVER LIMIT"
+		} else if u.HighWater {
+			status = "high-water"
+		}
+		fmt.Fprintf(&buf, "| %s | `%s` | %d | %d | %.0f%% | %s |\n", u.ConfigMapName, u.Glob, u.RawBytes, u.GzipBytes, u.UsageFraction*100, status)
+	}
+	return buf.String()
+}
+
+func payloadSizes(releaseRepoDir string, files []string) (raw int64, gzipped int64, err error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	for _, f := range files {
+		content, readErr := ioutil.ReadFile(path.Join(releaseRepoDir, f))
+		if readErr != nil {
+			return 0, 0, fmt.Errorf("could not read %s: %w", f, readErr)
+		}
+		raw += int64(len(content))
+		if _, writeErr := w.Write(content); writeErr != nil {
+			return 0, 0, fmt.Errorf("could not gzip %s: %w", f, writeErr)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, 0, fmt.Errorf("could not finalize gzip stream: %w", err)
+	}
+	return raw, int64(buf.Len()), nil
+}
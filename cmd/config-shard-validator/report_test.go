@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestPayloadSizes(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{
+		"a.yaml": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n",
+		"b.yaml": "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb\n",
+	}
+	var files []string
+	var wantRaw int64
+	for name, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, name)
+		wantRaw += int64(len(content))
+	}
+
+	raw, gzipped, err := payloadSizes(dir, files)
+	if err != nil {
+		t.Fatalf("payloadSizes() returned error: %v", err)
+	}
+	if raw != wantRaw {
+		t.Errorf("payloadSizes() raw = %d, want %d", raw, wantRaw)
+	}
+	if gzipped <= 0 {
+		t.Error("expected a positive gzip-compressed size")
+	}
+	if gzipped >= raw {
+		t.Errorf("expected gzip to compress this highly repetitive content (raw=%d, gzip=%d)", raw, gzipped)
+	}
+}
+
+func TestPayloadSizesMissingFile(t *testing.T) {
+	if _, _, err := payloadSizes(t.TempDir(), []string{"does-not-exist.yaml"}); err == nil {
+		t.Error("expected payloadSizes to error on a missing file")
+	}
+}
+
+func TestClassifyUsage(t *testing.T) {
+	cases := []struct {
+		name          string
+		gzipBytes     int64
+		threshold     float64
+		wantHighWater bool
+		wantOverLimit bool
+	}{
+		{name: "well under budget", gzipBytes: 100, threshold: 0.8, wantHighWater: false, wantOverLimit: false},
+		{name: "exactly at high-water threshold", gzipBytes: k8sConfigMapByteLimit / 2, threshold: 0.5, wantHighWater: true, wantOverLimit: false},
+		{name: "over the hard limit", gzipBytes: k8sConfigMapByteLimit + 1, threshold: 0.8, wantHighWater: true, wantOverLimit: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fraction, highWater, overLimit := classifyUsage(tc.gzipBytes, tc.threshold)
+			if wantFraction := float64(tc.gzipBytes) / float64(k8sConfigMapByteLimit); fraction != wantFraction {
+				t.Errorf("classifyUsage() fraction = %v, want %v", fraction, wantFraction)
+			}
+			if highWater != tc.wantHighWater {
+				t.Errorf("classifyUsage() highWater = %v, want %v", highWater, tc.wantHighWater)
+			}
+			if overLimit != tc.wantOverLimit {
+				t.Errorf("classifyUsage() overLimit = %v, want %v", overLimit, tc.wantOverLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/openshift/ci-tools/pkg/config"
+)
+
+// shardSuffix matches a trailing "-NN" shard suffix on a ConfigMap name, the
+// form runShardMode gives the shards of a group that outgrows a single
+// ConfigMap.
+//
+// KNOWN GAP: this tool computes its own shard assignment (fnv32a hash of
+// info.Basename(), mod shard count) entirely within cmd/config-shard-validator
+// and does not call into, or share code with, config.Info/jobconfig.Info's
+// own ConfigMapName(). That function lives in pkg/config, which this CLI
+// only ever imports, never modifies -- changing its sharding algorithm is
+// out of scope here. Until pkg/config.ConfigMapName() is updated to use this
+// same hash-of-basename/shard-count/"-NN"-suffix scheme, --shard's output is
+// a proposal for what config_updater.maps *should* say, not a guarantee that
+// it matches what ConfigMapName() computes for a given file at runtime; a
+// divergence would silently produce CONFIG_SPEC references checkSpec can't
+// validate against the actual shard a file landed in. runShardMode logs a
+// warning to this effect on every run (see below) rather than presenting
+// sharded output as already reconciled with pkg/config.
+var shardSuffix = regexp.MustCompile(`-(\d+)$`)
+
+func baseConfigMapName(name string) string {
+	return shardSuffix.ReplaceAllString(name, "")
+}
+
+// runShardMode groups pathsToCheck by their base ConfigMap (the name with any
+// existing shard suffix stripped), assigns each file to a shard keyed by a
+// stable hash of its basename, and emits the config_updater.maps glob
+// entries for the resulting shards. It only grows the shard count for a
+// group once the group's current shard count overflows maxShardBytesGzip, so
+// re-running it against an unchanged tree produces no churn.
+func runShardMode(o options, pathsToCheck []pathWithConfig) error {
+	logrus.Warn("--shard computes its own shard assignment independently of config.Info/jobconfig.Info's ConfigMapName(); treat its config_updater.maps output as a proposal to reconcile with pkg/config, not as already matching what CONFIG_SPEC will resolve to at runtime.")
+
+	groups := map[string][]pathWithConfig{}
+	for _, p := range pathsToCheck {
+		base := baseConfigMapName(p.configMap)
+		groups[base] = append(groups[base], p)
+	}
+
+	bases := make([]string, 0, len(groups))
+	for base := range groups {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	pluginConfigPath := path.Join(o.releaseRepoDir, config.PluginConfigInRepoPath)
+	raw, err := ioutil.ReadFile(pluginConfigPath)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", pluginConfigPath, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return fmt.Errorf("could not parse %s: %w", pluginConfigPath, err)
+	}
+	mapsNode, err := findOrCreateMapsNode(&root)
+	if err != nil {
+		return err
+	}
+
+	for _, base := range bases {
+		// computeShards only ever returns once every shard's gzippedBytes
+		// fits under maxShardBytesGzip (or errors out itself once
+		// maxShardCount is exhausted), so there is no over-budget shard left
+		// to fail-fast on here -- that's computeShards' job, not this loop's.
+		shards, err := computeShards(o.releaseRepoDir, base, groups[base], o.maxShardBytesGzip)
+		if err != nil {
+			return fmt.Errorf("group %s: %w", base, err)
+		}
+		for i, shard := range shards {
+			name := base
+			if len(shards) > 1 {
+				name = fmt.Sprintf("%s-%02d", base, i)
+			}
+			var added int
+			for _, glob := range shardGlobs(shard, len(shards) > 1) {
+				if globExists(mapsNode, glob) {
+					continue
+				}
+				appendGzipMapEntry(mapsNode, glob, name)
+				added++
+			}
+			if added > 0 {
+				logrus.WithFields(logrus.Fields{"config-map": name, "globs": added, "bytes": shard.gzippedBytes}).Info("Assigned shard.")
+			}
+		}
+	}
+
+	out, err := yaml.Marshal(&root)
+	if err != nil {
+		return fmt.Errorf("could not re-marshal %s: %w", pluginConfigPath, err)
+	}
+	if o.dryRun {
+		fmt.Printf("--- %s (proposed)\n%s\n", pluginConfigPath, out)
+		return nil
+	}
+	return ioutil.WriteFile(pluginConfigPath, out, 0644)
+}
+
+type shard struct {
+	files        []pathWithConfig
+	gzippedBytes int64
+}
+
+// shardGlobs returns the config_updater.maps glob entries that match exactly
+// the files assigned to this shard. A group that fit in a single shard can
+// still be matched with one directory wildcard, same as before sharding
+// existed. Once a group is split across multiple shards, a wildcard can no
+// longer express the partition -- which file lands in which shard depends on
+// a hash of its basename, not on its directory -- so each file gets its own
+// literal-path entry; zglob.Match treats a glob with no metacharacters as an
+// exact match, so this still partitions correctly.
+func shardGlobs(s shard, multiShard bool) []string {
+	if len(s.files) == 0 {
+		return nil
+	}
+	if !multiShard {
+		return []string{path.Join(filepath.Dir(s.files[0].path), "*")}
+	}
+	globs := make([]string, 0, len(s.files))
+	for _, f := range s.files {
+		globs = append(globs, f.path)
+	}
+	return globs
+}
+
+// computeShards assigns files to a stable number of shards, keyed by a hash
+// of each file's basename, growing the shard count by one and re-hashing
+// only when the current count overflows maxShardBytesGzip.
+func computeShards(releaseRepoDir, base string, files []pathWithConfig, maxShardBytesGzip int64) ([]shard, error) {
+	shardCount := 1
+	const maxShardCount = 64
+	for {
+		shards := make([]shard, shardCount)
+		for _, f := range files {
+			idx := shardIndex(filepath.Base(f.path), shardCount)
+			shards[idx].files = append(shards[idx].files, f)
+		}
+
+		overflowed := false
+		for i := range shards {
+			if len(shards[i].files) == 0 {
+				continue
+			}
+			size, err := gzippedSize(releaseRepoDir, shards[i].files)
+			if err != nil {
+				return nil, err
+			}
+			shards[i].gzippedBytes = size
+			if size > maxShardBytesGzip {
+				overflowed = true
+			}
+		}
+
+		if !overflowed {
+			return compact(shards), nil
+		}
+		shardCount++
+		if shardCount > maxShardCount {
+			return nil, fmt.Errorf("could not fit %q under %d gzipped bytes even with %d shards", base, maxShardBytesGzip, maxShardCount)
+		}
+	}
+}
+
+func compact(shards []shard) []shard {
+	var out []shard
+	for _, s := range shards {
+		if len(s.files) > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func shardIndex(basename string, shardCount int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(basename))
+	return int(h.Sum32() % uint32(shardCount))
+}
+
+func gzippedSize(releaseRepoDir string, files []pathWithConfig) (int64, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	for _, f := range files {
+		content, err := ioutil.ReadFile(path.Join(releaseRepoDir, f.path))
+		if err != nil {
+			return 0, fmt.Errorf("could not read %s: %w", f.path, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			return 0, fmt.Errorf("could not gzip %s: %w", f.path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("could not finalize gzip stream: %w", err)
+	}
+	return int64(buf.Len()), nil
+}
+
+// appendGzipMapEntry adds `glob: {name: configMapName, gzip: true}` to the
+// end of the config_updater.maps mapping node.
+func appendGzipMapEntry(maps *yaml.Node, glob, configMapName string) {
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: glob}
+	nameKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"}
+	nameValue := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: configMapName}
+	gzipKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "gzip"}
+	gzipValue := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"}
+	valueNode := &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Content: []*yaml.Node{nameKey, nameValue, gzipKey, gzipValue},
+	}
+	maps.Content = append(maps.Content, keyNode, valueNode)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardIndexStable(t *testing.T) {
+	for _, basename := range []string{"a.yaml", "b.yaml", "deeply-nested-config.yaml"} {
+		first := shardIndex(basename, 8)
+		for i := 0; i < 10; i++ {
+			if got := shardIndex(basename, 8); got != first {
+				t.Fatalf("shardIndex(%q, 8) is not stable across calls: got %d and %d", basename, first, got)
+			}
+		}
+	}
+}
+
+func TestComputeShardsPartitionsFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []pathWithConfig
+	for i := 0; i < 20; i++ {
+		name := filepath.Join("ci-operator", "config", "org", "repo", "file-"+string(rune('a'+i))+".yaml")
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		content := make([]byte, 200)
+		for j := range content {
+			content[j] = byte('a' + i)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, pathWithConfig{path: name, configMap: "base"})
+	}
+
+	shards, err := computeShards(dir, "base", files, 50)
+	if err != nil {
+		t.Fatalf("computeShards() returned error: %v", err)
+	}
+	if len(shards) < 2 {
+		t.Fatalf("expected files to be split across more than one shard given the tiny budget, got %d shard(s)", len(shards))
+	}
+
+	seen := map[string]bool{}
+	for _, s := range shards {
+		for _, f := range s.files {
+			if seen[f.path] {
+				t.Fatalf("file %s assigned to more than one shard", f.path)
+			}
+			seen[f.path] = true
+		}
+	}
+	if len(seen) != len(files) {
+		t.Fatalf("expected every file to be assigned to exactly one shard, got %d of %d", len(seen), len(files))
+	}
+}
+
+func TestShardGlobsPartitionMultiShardGroups(t *testing.T) {
+	shards := []shard{
+		{files: []pathWithConfig{{path: "ci-operator/config/org/repo/a.yaml"}, {path: "ci-operator/config/org/repo/b.yaml"}}},
+		{files: []pathWithConfig{{path: "ci-operator/config/org/repo/c.yaml"}}},
+	}
+
+	seenGlobs := map[string]bool{}
+	for _, s := range shards {
+		for _, glob := range shardGlobs(s, true) {
+			if seenGlobs[glob] {
+				t.Fatalf("glob %q emitted for more than one shard; shards are not disjoint", glob)
+			}
+			seenGlobs[glob] = true
+		}
+	}
+	for _, s := range shards {
+		for _, f := range s.files {
+			if !seenGlobs[f.path] {
+				t.Fatalf("no glob emitted for file %s", f.path)
+			}
+		}
+	}
+
+	single := shard{files: []pathWithConfig{{path: "ci-operator/config/org/repo/a.yaml"}}}
+	globs := shardGlobs(single, false)
+	if len(globs) != 1 || globs[0] != "ci-operator/config/org/repo/*" {
+		t.Fatalf("expected a single directory wildcard for an unsplit group, got %v", globs)
+	}
+}
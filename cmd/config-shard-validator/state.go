@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/sirupsen/logrus"
+
+	ciio "github.com/openshift/ci-tools/pkg/io"
+)
+
+// lintState is the persisted baseline written to --state-path: the set of
+// violation keys that were known at the time of the last run. Every run
+// reads it back in, logs anything still present as a warning instead of an
+// error, and rewrites it with whatever violations are present this time, so
+// the baseline shrinks as violations are fixed and never grows silently.
+type lintState struct {
+	Violations map[string]bool `json:"violations"`
+}
+
+// recorder tracks every violation found during a run and, when a prior
+// lintState was loaded, ratchets: only violations absent from that state are
+// treated as failures, pre-existing ones are logged as warnings so the
+// checker can be turned on against a dirty repo without a big-bang cleanup.
+type recorder struct {
+	usingState bool
+	bootstrap  bool // true on the first run against a --state-path with no prior baseline on disk
+	prior      *lintState
+	seen       map[string]bool
+
+	anyViolation    bool
+	newlyIntroduced bool
+}
+
+func newRecorder(usingState, bootstrap bool, prior *lintState) *recorder {
+	return &recorder{usingState: usingState, bootstrap: bootstrap, prior: prior, seen: map[string]bool{}}
+}
+
+// violation records a single violation, identified by a key that is stable
+// across runs (it is what gets diffed against the persisted baseline), and
+// logs it at Warn if it was already known, or Error if it is new.
+func (r *recorder) violation(logger *logrus.Entry, key, message string) {
+	r.seen[key] = true
+	r.anyViolation = true
+	if r.usingState && (r.bootstrap || r.prior.Violations[key]) {
+		logger.Warn(message + " (pre-existing violation, not failing the build)")
+		return
+	}
+	logger.Error(message)
+	r.newlyIntroduced = true
+}
+
+// failed reports whether this run should exit non-zero: when ratcheting
+// against a --state-path baseline, only newly-introduced violations count
+// (and a bootstrap run, which has no baseline yet, never fails); otherwise
+// every violation does, matching the tool's historical behavior.
+func (r *recorder) failed() bool {
+	if r.usingState {
+		return r.newlyIntroduced
+	}
+	return r.anyViolation
+}
+
+func (r *recorder) state() *lintState {
+	return &lintState{Violations: r.seen}
+}
+
+// loadState reads the baseline from statePath through opener, which resolves
+// a local file or a gs://bucket/object URI the same way the rest of ci-tools
+// does. A path that does not yet exist means this is a bootstrap run: every
+// violation found gets logged as a warning and folded into the baseline,
+// rather than failing the build, so the checker can be turned on against an
+// already-dirty repo.
+func loadState(ctx context.Context, statePath string, opener ciio.Opener) (state *lintState, bootstrap bool, err error) {
+	if statePath == "" {
+		return nil, false, nil
+	}
+
+	reader, err := opener.Reader(ctx, statePath)
+	if err != nil {
+		if isStatePathNotExist(err) {
+			return &lintState{Violations: map[string]bool{}}, true, nil
+		}
+		return nil, false, fmt.Errorf("could not open %s: %w", statePath, err)
+	}
+	defer reader.Close()
+
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not read %s: %w", statePath, err)
+	}
+
+	var loaded lintState
+	if err := json.Unmarshal(raw, &loaded); err != nil {
+		return nil, false, fmt.Errorf("could not parse %s: %w", statePath, err)
+	}
+	if loaded.Violations == nil {
+		loaded.Violations = map[string]bool{}
+	}
+	return &loaded, false, nil
+}
+
+// saveState writes state back out through opener, mirroring loadState's
+// local-or-GCS handling.
+func saveState(ctx context.Context, statePath string, state *lintState, opener ciio.Opener) error {
+	if statePath == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal state: %w", err)
+	}
+
+	writer, err := opener.Writer(ctx, statePath)
+	if err != nil {
+		return fmt.Errorf("could not open %s for writing: %w", statePath, err)
+	}
+	if _, err := writer.Write(raw); err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("could not write %s: %w", statePath, err)
+	}
+	return writer.Close()
+}
+
+// isStatePathNotExist reports whether err indicates statePath simply hasn't
+// been written yet, for either of the backends opener may have used.
+func isStatePathNotExist(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, storage.ErrObjectNotExist)
+}
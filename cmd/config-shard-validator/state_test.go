@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeOpener is an in-memory ciio.Opener double, so loadState/saveState's
+// behavior around a missing object and a read/write round trip can be
+// exercised without a real GCS backend or local filesystem -- exactly the
+// paths that cloud.google.com/go/storage-specific plumbing used to hide from
+// coverage.
+type fakeOpener struct {
+	objects map[string][]byte
+}
+
+func (f *fakeOpener) Reader(_ context.Context, path string) (io.ReadCloser, error) {
+	content, ok := f.objects[path]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (f *fakeOpener) Writer(_ context.Context, path string) (io.WriteCloser, error) {
+	return &fakeWriteCloser{opener: f, path: path}, nil
+}
+
+type fakeWriteCloser struct {
+	opener *fakeOpener
+	path   string
+	buf    bytes.Buffer
+}
+
+func (w *fakeWriteCloser) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *fakeWriteCloser) Close() error {
+	if w.opener.objects == nil {
+		w.opener.objects = map[string][]byte{}
+	}
+	w.opener.objects[w.path] = w.buf.Bytes()
+	return nil
+}
+
+func TestRecorderWithoutStateFailsOnEveryViolation(t *testing.T) {
+	rec := newRecorder(false, false, nil)
+	rec.violation(logrus.WithField("t", t.Name()), "k1", "msg")
+	if !rec.failed() {
+		t.Error("expected a run with no --state-path to fail on any violation")
+	}
+}
+
+func TestRecorderRatchetsAgainstPriorBaseline(t *testing.T) {
+	prior := &lintState{Violations: map[string]bool{"pre-existing": true}}
+	rec := newRecorder(true, false, prior)
+	rec.violation(logrus.WithField("t", t.Name()), "pre-existing", "msg")
+	if rec.failed() {
+		t.Error("expected a pre-existing violation to be ratcheted (warned, not failed)")
+	}
+
+	rec.violation(logrus.WithField("t", t.Name()), "brand-new", "msg")
+	if !rec.failed() {
+		t.Error("expected a newly-introduced violation to fail the run even when ratcheting")
+	}
+}
+
+func TestRecorderBootstrapNeverFails(t *testing.T) {
+	rec := newRecorder(true, true, &lintState{Violations: map[string]bool{}})
+	rec.violation(logrus.WithField("t", t.Name()), "anything", "msg")
+	rec.violation(logrus.WithField("t", t.Name()), "something-else", "msg")
+	if rec.failed() {
+		t.Error("expected a bootstrap run (no baseline yet) to never fail")
+	}
+	if len(rec.state().Violations) != 2 {
+		t.Errorf("expected the bootstrap run's violations to be captured into the new baseline, got %v", rec.state().Violations)
+	}
+}
+
+func TestLoadStateMissingObjectBootstraps(t *testing.T) {
+	opener := &fakeOpener{}
+	state, bootstrap, err := loadState(context.Background(), "gs://bucket/does-not-exist.json", opener)
+	if err != nil {
+		t.Fatalf("loadState() returned error: %v", err)
+	}
+	if !bootstrap {
+		t.Error("expected a missing state object to be treated as a bootstrap run")
+	}
+	if len(state.Violations) != 0 {
+		t.Errorf("expected an empty baseline for a bootstrap run, got %v", state.Violations)
+	}
+}
+
+func TestSaveAndLoadStateRoundTrip(t *testing.T) {
+	opener := &fakeOpener{}
+	want := &lintState{Violations: map[string]bool{"a": true, "b": true}}
+
+	for _, statePath := range []string{"/local/state.json", "gs://bucket/state.json"} {
+		if err := saveState(context.Background(), statePath, want, opener); err != nil {
+			t.Fatalf("saveState(%q) returned error: %v", statePath, err)
+		}
+
+		got, bootstrap, err := loadState(context.Background(), statePath, opener)
+		if err != nil {
+			t.Fatalf("loadState(%q) returned error: %v", statePath, err)
+		}
+		if bootstrap {
+			t.Errorf("loadState(%q): expected to find the object saveState just wrote, not bootstrap", statePath)
+		}
+		if len(got.Violations) != 2 || !got.Violations["a"] || !got.Violations["b"] {
+			t.Errorf("loadState(%q) = %v, want %v", statePath, got.Violations, want.Violations)
+		}
+	}
+}
+
+func TestLoadStateEmptyPathIsNotBootstrap(t *testing.T) {
+	state, bootstrap, err := loadState(context.Background(), "", &fakeOpener{})
+	if err != nil {
+		t.Fatalf("loadState() returned error: %v", err)
+	}
+	if bootstrap || state != nil {
+		t.Errorf("expected no --state-path at all to disable ratcheting entirely, got state=%v bootstrap=%v", state, bootstrap)
+	}
+}
+
+func TestLoadStateRejectsMalformedJSON(t *testing.T) {
+	opener := &fakeOpener{objects: map[string][]byte{"gs://bucket/state.json": []byte("not json")}}
+	if _, _, err := loadState(context.Background(), "gs://bucket/state.json", opener); err == nil {
+		t.Error("expected loadState to reject malformed JSON")
+	}
+}
+
+func TestIsStatePathNotExist(t *testing.T) {
+	if !isStatePathNotExist(storage.ErrObjectNotExist) {
+		t.Error("expected storage.ErrObjectNotExist to be treated as not-exist")
+	}
+	if isStatePathNotExist(io.EOF) {
+		t.Error("expected an unrelated error not to be treated as not-exist")
+	}
+}